@@ -8,8 +8,12 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/url"
+	"path/filepath"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/influxdata/telegraf"
@@ -21,12 +25,83 @@ import (
 var DefaultResponseHeaderTimeout = internal.Duration{Duration: 3 * time.Second}
 var DefaultClientTimeout = internal.Duration{Duration: 4 * time.Second}
 
+// DefaultMaxConcurrentRequests caps how many servers are gathered at once.
+const DefaultMaxConcurrentRequests = 4
+
+// Defaults for the optional discovery block.
+var DefaultDiscoveryInterval = internal.Duration{Duration: 60 * time.Second}
+var DefaultDiscoveryTimeout = internal.Duration{Duration: 2 * time.Second}
+var DefaultDiscoveryTTL = internal.Duration{Duration: 5 * time.Minute}
+
+// jolokiaDiscoveryAddress is the well-known multicast group Jolokia agents
+// listen on for discovery queries.
+const jolokiaDiscoveryAddress = "239.192.48.84:24884"
+
 type Server struct {
 	Name     string
 	Host     string
 	Username string
 	Password string
 	Port     string
+
+	// Tags is merged into the point tags for this server. It is only
+	// populated for servers found through multicast discovery.
+	Tags map[string]string
+}
+
+// Discovery configures optional multicast discovery of Jolokia agents, used
+// to populate Servers dynamically instead of hand-maintaining a list.
+type Discovery struct {
+	Enabled bool `toml:"enabled"`
+
+	// Interval between discovery queries.
+	Interval internal.Duration `toml:"interval"`
+	// Timeout to wait for agents to respond to a query.
+	Timeout internal.Duration `toml:"timeout"`
+	// TTL is how long a discovered agent is kept after its last response
+	// before it is dropped from the effective server list.
+	TTL internal.Duration `toml:"ttl"`
+}
+
+// discoveredServer tracks when a dynamically discovered agent was last seen,
+// so it can be expired once TTL has elapsed.
+type discoveredServer struct {
+	Server   Server
+	LastSeen time.Time
+}
+
+// discoveryResponse is the JSON payload a Jolokia agent replies with to a
+// multicast discovery query.
+type discoveryResponse struct {
+	URL           string `json:"url"`
+	AgentID       string `json:"agent_id"`
+	ServerVendor  string `json:"server_vendor"`
+	ServerProduct string `json:"server_product"`
+	ServerVersion string `json:"server_version"`
+}
+
+func (r discoveryResponse) toServer() (Server, error) {
+	u, err := url.Parse(r.URL)
+	if err != nil {
+		return Server{}, fmt.Errorf("invalid agent url %q: %s", r.URL, err)
+	}
+
+	name := r.AgentID
+	if name == "" {
+		name = u.Host
+	}
+
+	return Server{
+		Name: name,
+		Host: u.Hostname(),
+		Port: u.Port(),
+		Tags: map[string]string{
+			"jolokia_agent_id":       r.AgentID,
+			"jolokia_server_vendor":  r.ServerVendor,
+			"jolokia_server_product": r.ServerProduct,
+			"jolokia_server_version": r.ServerVersion,
+		},
+	}, nil
 }
 
 type Metric struct {
@@ -35,6 +110,31 @@ type Metric struct {
 	TagsFromMbean     []string
 	Attribute         string
 	Path              string
+
+	// Type is the Jolokia operation to perform: "read" (default), "exec" or
+	// "search". "exec" additionally requires Operation and, optionally,
+	// Arguments. "search" expands into one "read" per mbean matching Mbean
+	// (which may contain wildcards) before Gather collects values.
+	Type              string
+	Operation         string
+	Arguments         []string
+
+	// FieldName overrides Name as the root of the flattened field name.
+	FieldName         string `toml:"field_name"`
+	// FieldPrefix is prepended to every field name produced by this metric.
+	FieldPrefix       string `toml:"field_prefix"`
+	// FieldType coerces field values to "int", "float", "bool" or "string".
+	FieldType         string `toml:"field_type"`
+
+	// Include, if non-empty, keeps only fields whose name matches one of
+	// these glob patterns. Exclude drops fields matching any of its
+	// patterns. Exclude is applied after Include.
+	Include           []string `toml:"include"`
+	Exclude           []string `toml:"exclude"`
+
+	// TagKeys promotes these map keys, when encountered while flattening a
+	// nested result, into tags instead of part of the field name.
+	TagKeys           []string `toml:"tag_keys"`
 }
 
 type JolokiaClient interface {
@@ -72,7 +172,22 @@ type Jolokia struct {
 	ResponseHeaderTimeout internal.Duration `toml:"response_header_timeout"`
 	ClientTimeout         internal.Duration `toml:"client_timeout"`
 
+	// MaxConcurrentRequests bounds how many servers are gathered at once.
+	MaxConcurrentRequests int `toml:"max_concurrent_requests"`
+
+	// Discovery optionally populates Servers from agents found via
+	// multicast, in addition to the statically configured ones.
+	Discovery Discovery `toml:"discovery"`
+
+	// Log is injected by the agent on startup.
+	Log telegraf.Logger `toml:"-"`
+
 	tlsConfig             tls.Config
+
+	discoveryMu       sync.Mutex
+	discoveredServers map[string]discoveredServer
+	discoveryStop     chan struct{}
+	discoveryWg       sync.WaitGroup
 }
 
 const sampleConfig = `
@@ -117,6 +232,20 @@ const sampleConfig = `
   ## name, and the attribute name, separated by the given delimiter.
   # delimiter = "_"
 
+  ## Maximum number of servers gathered concurrently. Raise this when
+  ## polling many servers so the whole gather still fits inside the
+  ## collection interval.
+  # max_concurrent_requests = 4
+
+  ## Optional discovery of Jolokia agents via UDP multicast. Discovered
+  ## agents are merged with the servers listed below and dropped again
+  ## once they stop responding for longer than ttl.
+  # [inputs.jolokia.discovery]
+  #   enabled = true
+  #   interval = "60s"
+  #   timeout = "2s"
+  #   ttl = "5m"
+
   ## List of servers exposing jolokia read service
   [[inputs.jolokia.servers]]
     name = "as-server-01"
@@ -145,6 +274,38 @@ const sampleConfig = `
     name = "class_count"
     mbean  = "java.lang:type=ClassLoading"
     attribute = "LoadedClassCount,UnloadedClassCount,TotalLoadedClassCount"
+
+  ## A metric can also invoke a JMX operation via "type = \"exec\"", or
+  ## expand an mbean wildcard pattern into one read per match via
+  ## "type = \"search\"". The type defaults to "read" when omitted.
+  # [[inputs.jolokia.metrics]]
+  #   name = "thread_pools"
+  #   mbean = "Catalina:type=ThreadPool,name=*"
+  #   type = "search"
+
+  ## This triggers a JMX operation (here, a garbage collection) on every
+  ## gather instead of reading an attribute. Operations with no return
+  ## value are fine; nothing is reported for them.
+  # [[inputs.jolokia.metrics]]
+  #   name = "force_gc"
+  #   mbean  = "java.lang:type=Memory"
+  #   operation = "gc"
+  #   type = "exec"
+  #   # arguments = ["arg1", "arg2"]
+
+  ## A metric can rename and coerce the fields it produces, and filter out
+  ## noisy sub-attributes.
+  # [[inputs.jolokia.metrics]]
+  #   name = "heap_memory_usage"
+  #   mbean  = "java.lang:type=Memory"
+  #   attribute = "HeapMemoryUsage"
+  #   field_name = "heap"
+  #   field_prefix = "jvm_"
+  #   field_type = "int"
+  #   include = ["*used", "*max"]
+  #   exclude = ["*committed"]
+  #   ## Promote these nested result keys into tags instead of field names.
+  #   tag_keys = ["name"]
 `
 
 func (j *Jolokia) SampleConfig() string {
@@ -155,7 +316,17 @@ func (j *Jolokia) Description() string {
 	return "Read JMX metrics through Jolokia"
 }
 
-func (j *Jolokia) doRequest(req *http.Request) (map[string]interface{}, error) {
+// doBulkRequest returns one result per metric, in the same order as
+// metrics, so callers can pair each response back to the exact metric that
+// produced it. This matters once multiple metrics share the same Name (as
+// happens after search expansion) -- keying by Name alone would bucket them
+// together and make every match indistinguishable from the others.
+//
+// A non-200 status for an individual metric is a partial failure of an
+// otherwise successful bulk request: it's reported via acc.AddError so it
+// surfaces in internal_gather stats, and the corresponding result is left
+// nil rather than failing the whole request.
+func (j *Jolokia) doBulkRequest(serverName string, req *http.Request, metrics []Metric, acc telegraf.Accumulator) ([]map[string]interface{}, error) {
 	resp, err := j.jClient.MakeRequest(req)
 	if err != nil {
 		return nil, err
@@ -179,39 +350,73 @@ func (j *Jolokia) doRequest(req *http.Request) (map[string]interface{}, error) {
 		return nil, err
 	}
 
-	// Unmarshal json
-	var jsonOut map[string]interface{}
-	if err = json.Unmarshal([]byte(body), &jsonOut); err != nil {
+	// Unmarshal the bulk response: jolokia returns a JSON array of results,
+	// one per read request, in the same order they were submitted.
+	var jsonOut []map[string]interface{}
+	if err = json.Unmarshal(body, &jsonOut); err != nil {
 		return nil, errors.New("Error decoding JSON response")
 	}
 
-	if status, ok := jsonOut["status"]; ok {
+	if len(jsonOut) != len(metrics) {
+		return nil, fmt.Errorf("Expected %d responses, got %d", len(metrics), len(jsonOut))
+	}
+
+	results := make([]map[string]interface{}, len(metrics))
+	for i, out := range jsonOut {
+		metric := metrics[i]
+		status, ok := out["status"]
+		if !ok {
+			return nil, fmt.Errorf("Missing status in response body for metric %s", metric.Name)
+		}
 		if status != float64(200) {
-			return nil, fmt.Errorf("Not expected status value in response body: %3.f",
-				status)
+			acc.AddError(fmt.Errorf("server %s, mbean %s: unexpected status %.0f in response for metric %s",
+				serverName, metric.Mbean, status, metric.Name))
+			continue
 		}
-	} else {
-		return nil, fmt.Errorf("Missing status in response body")
+		results[i] = out
 	}
 
-	return jsonOut, nil
+	return results, nil
 }
 
-func (j *Jolokia) prepareRequest(server Server, metric Metric) (*http.Request, error) {
+func (j *Jolokia) prepareRequest(server Server, metrics []Metric) (*http.Request, error) {
 	var jolokiaUrl *url.URL
 	context := j.Context // Usually "/jolokia/"
 
-	// Create bodyContent
-	bodyContent := map[string]interface{}{
-		"type":  "read",
-		"mbean": metric.Mbean,
-	}
+	// Create the bulk bodyContent: one operation per metric, submitted
+	// together in a single JSON array so Jolokia answers with one round-trip.
+	bodyContent := make([]map[string]interface{}, 0, len(metrics))
+	for _, metric := range metrics {
+		metricType := metric.Type
+		if metricType == "" {
+			metricType = "read"
+		}
 
-	if metric.Attribute != "" {
-		bodyContent["attribute"] = metric.Attribute
-		if metric.Path != "" {
-			bodyContent["path"] = metric.Path
+		item := map[string]interface{}{
+			"type":  metricType,
+			"mbean": metric.Mbean,
 		}
+
+		switch metricType {
+		case "exec":
+			item["operation"] = metric.Operation
+			if len(metric.Arguments) > 0 {
+				arguments := make([]interface{}, len(metric.Arguments))
+				for i, a := range metric.Arguments {
+					arguments[i] = a
+				}
+				item["arguments"] = arguments
+			}
+		default:
+			if metric.Attribute != "" {
+				item["attribute"] = metric.Attribute
+				if metric.Path != "" {
+					item["path"] = metric.Path
+				}
+			}
+		}
+
+		bodyContent = append(bodyContent, item)
 	}
 
 	// Add target, only in proxy mode
@@ -231,7 +436,9 @@ func (j *Jolokia) prepareRequest(server Server, metric Metric) (*http.Request, e
 			target["password"] = server.Password
 		}
 
-		bodyContent["target"] = target
+		for _, item := range bodyContent {
+			item["target"] = target
+		}
 
 		proxy := j.Proxy
 
@@ -314,14 +521,113 @@ func (j *Jolokia) parseTags(
 	return tags, nil
 }
 
-func (j *Jolokia) extractValues(key string, value interface{}, fields map[string]interface{}) {
+// fieldAllowed reports whether key passes metric's include/exclude glob
+// filters. Exclude is checked after Include and always wins.
+func fieldAllowed(metric Metric, key string) bool {
+	if len(metric.Include) > 0 {
+		allowed := false
+		for _, pattern := range metric.Include {
+			if ok, _ := filepath.Match(pattern, key); ok {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return false
+		}
+	}
+
+	for _, pattern := range metric.Exclude {
+		if ok, _ := filepath.Match(pattern, key); ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+// coerceFieldType converts value to metric.FieldType ("int", "float", "bool"
+// or "string"). Values that can't be parsed are returned unchanged.
+func coerceFieldType(fieldType string, value interface{}) interface{} {
+	switch fieldType {
+	case "int":
+		switch v := value.(type) {
+		case float64:
+			return int64(v)
+		case string:
+			if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+				return n
+			}
+		}
+	case "float":
+		if v, ok := value.(string); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				return f
+			}
+		}
+	case "bool":
+		switch v := value.(type) {
+		case string:
+			if b, err := strconv.ParseBool(v); err == nil {
+				return b
+			}
+		case float64:
+			return v != 0
+		}
+	case "string":
+		return fmt.Sprintf("%v", value)
+	}
+
+	return value
+}
+
+func copyTags(tags map[string]string) map[string]string {
+	out := make(map[string]string, len(tags))
+	for k, v := range tags {
+		out[k] = v
+	}
+	return out
+}
+
+func (j *Jolokia) extractValues(
+	metric Metric, key string, value interface{},
+	fields map[string]interface{}, tags map[string]string,
+) {
 	if mapValues, ok := value.(map[string]interface{}); ok {
 		for k2, v2 := range mapValues {
-			j.extractValues(key + j.Delimiter + k2, v2, fields)
+			if containsString(metric.TagKeys, k2) {
+				tags[k2] = fmt.Sprintf("%v", v2)
+				continue
+			}
+			j.extractValues(metric, key+j.Delimiter+k2, v2, fields, tags)
 		}
-	} else {
-		fields[key] = value
+		return
 	}
+
+	if !fieldAllowed(metric, key) {
+		return
+	}
+
+	fields[key] = coerceFieldType(metric.FieldType, value)
+}
+
+func containsString(haystack []string, needle string) bool {
+	for _, s := range haystack {
+		if s == needle {
+			return true
+		}
+	}
+	return false
+}
+
+// metricFieldRoot is the root key extractValues flattens field names from,
+// honoring FieldName and FieldPrefix overrides.
+func metricFieldRoot(metric Metric) string {
+	root := metric.Name
+	if metric.FieldName != "" {
+		root = metric.FieldName
+	}
+	return metric.FieldPrefix + root
 }
 
 func (j* Jolokia) extractMetric(
@@ -330,34 +636,286 @@ func (j* Jolokia) extractMetric(
 ) error {
 	measurement := "jolokia";
 
-	if values, ok := input["value"]; ok {
-		if len(metric.TagsFromMbean) == 0 {
-			fields := make(map[string]interface{})
-			j.extractValues(metric.Name, values, fields)
-			acc.AddFields(measurement, fields, defaultTags)
+	values, ok := input["value"]
+	if !ok {
+		return fmt.Errorf("Missing key 'value' in output response\n")
+	}
+
+	if metric.Type == "exec" && values == nil {
+		// A void JMX operation (e.g. Memory.gc()) still returns a
+		// "value":null entry; there's nothing to report.
+		return nil
+	}
+
+	root := metricFieldRoot(metric)
+
+	if len(metric.TagsFromMbean) == 0 {
+		fields := make(map[string]interface{})
+		tags := copyTags(defaultTags)
+		j.extractValues(metric, root, values, fields, tags)
+		acc.AddFields(measurement, fields, tags)
+		return nil
+	}
+
+	mapValues, ok := values.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("There was no MBean name in output response\n")
+	}
+
+	for k, v := range mapValues {
+		fields := make(map[string]interface{})
+		tags, err := j.parseTags(k, metric.TagsFromMbean, defaultTags)
+		if err != nil {
+			acc.AddError(fmt.Errorf("metric %s, mbean %s: failed to parse tags: %s",
+				metric.Name, k, err))
+			continue
+		}
+		j.extractValues(metric, root, v, fields, tags)
+		acc.AddFields(measurement, fields, tags)
+	}
+
+	return nil
+}
+
+// extractMetrics dispatches the bulk result paired with each of metrics (by
+// position, as returned by doBulkRequest) through extractMetric. A nil
+// result (e.g. a non-200 status already reported by doBulkRequest) is
+// skipped. Metrics sharing the same Name -- as happens after search
+// expansion -- are each dispatched exactly once, since they're paired by
+// position rather than re-scanning a Name-keyed bucket.
+func (j *Jolokia) extractMetrics(
+	results []map[string]interface{}, metrics []Metric, defaultTags map[string]string,
+	acc telegraf.Accumulator,
+) {
+	for i, metric := range metrics {
+		out := results[i]
+		if out == nil {
+			continue
+		}
+		if err := j.extractMetric(out, metric, defaultTags, acc); err != nil {
+			acc.AddError(fmt.Errorf("server %s, mbean %s: error extracting metric %s: %s",
+				defaultTags["jolokia_name"], metric.Mbean, metric.Name, err))
+		}
+	}
+}
+
+// expandSearchMetrics resolves every "search" metric against server into the
+// list of mbeans it matches, and returns metrics with each search replaced
+// by one "read" metric per match. Metrics of any other type pass through
+// unchanged.
+func (j *Jolokia) expandSearchMetrics(server Server, metrics []Metric, acc telegraf.Accumulator) ([]Metric, error) {
+	searches := make([]Metric, 0)
+	expanded := make([]Metric, 0, len(metrics))
+	for _, metric := range metrics {
+		if metric.Type == "search" {
+			searches = append(searches, metric)
 		} else {
-			if mapValues, ok := values.(map[string]interface{}); ok {
-				for k, v := range mapValues {
-					fields := make(map[string]interface{})
-					tags, err := j.parseTags(k, metric.TagsFromMbean, defaultTags)
-					if (err != nil) {
-						fmt.Printf("Failed to parse tags: %s", err)
-					} else {
-						j.extractValues(metric.Name, v, fields)
-						acc.AddFields(measurement, fields, tags)
-					}
-				}
-			} else {
-				return fmt.Errorf("There was no MBean name in output response\n")
+			expanded = append(expanded, metric)
+		}
+	}
+
+	if len(searches) == 0 {
+		return expanded, nil
+	}
+
+	req, err := j.prepareRequest(server, searches)
+	if err != nil {
+		return nil, err
+	}
+
+	results, err := j.doBulkRequest(server.Name, req, searches, acc)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, metric := range searches {
+		out := results[i]
+		if out == nil {
+			continue
+		}
+
+		names, ok := out["value"].([]interface{})
+		if !ok {
+			if j.Log != nil {
+				j.Log.Warnf("server %s, mbean %s: unexpected search result for metric %s",
+					server.Name, metric.Mbean, metric.Name)
 			}
+			continue
 		}
-	} else {
-		return fmt.Errorf("Missing key 'value' in output response\n")
+
+		for _, name := range names {
+			mbean, ok := name.(string)
+			if !ok {
+				continue
+			}
+			read := metric
+			read.Mbean = mbean
+			read.Type = "read"
+			expanded = append(expanded, read)
+		}
+	}
+
+	return expanded, nil
+}
+
+// discoverServers sends a multicast discovery query and merges every agent
+// that answers into j.discoveredServers, then expires entries whose TTL has
+// elapsed since their last response.
+func (j *Jolokia) discoverServers() {
+	groupAddr, err := net.ResolveUDPAddr("udp4", jolokiaDiscoveryAddress)
+	if err != nil {
+		if j.Log != nil {
+			j.Log.Warnf("discovery: error resolving multicast address: %s", err)
+		}
+		return
+	}
+
+	conn, err := net.ListenPacket("udp4", "0.0.0.0:0")
+	if err != nil {
+		if j.Log != nil {
+			j.Log.Warnf("discovery: error opening socket: %s", err)
+		}
+		return
 	}
+	defer conn.Close()
+
+	query, _ := json.Marshal(map[string]string{"type": "query"})
+	if _, err := conn.WriteTo(query, groupAddr); err != nil {
+		if j.Log != nil {
+			j.Log.Warnf("discovery: error sending query: %s", err)
+		}
+		return
+	}
+
+	timeout := j.Discovery.Timeout.Duration
+	if timeout <= 0 {
+		timeout = DefaultDiscoveryTimeout.Duration
+	}
+	conn.SetReadDeadline(time.Now().Add(timeout))
+
+	now := time.Now()
+	found := make(map[string]Server)
+	buf := make([]byte, 8192)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			break // timeout reached, discovery window is over
+		}
+
+		var resp discoveryResponse
+		if err := json.Unmarshal(buf[:n], &resp); err != nil {
+			if j.Log != nil {
+				j.Log.Warnf("discovery: error decoding response: %s", err)
+			}
+			continue
+		}
+
+		server, err := resp.toServer()
+		if err != nil {
+			if j.Log != nil {
+				j.Log.Warnf("discovery: %s", err)
+			}
+			continue
+		}
+
+		found[resp.URL] = server
+	}
+
+	ttl := j.Discovery.TTL.Duration
+	if ttl <= 0 {
+		ttl = DefaultDiscoveryTTL.Duration
+	}
+
+	j.discoveryMu.Lock()
+	defer j.discoveryMu.Unlock()
+
+	if j.discoveredServers == nil {
+		j.discoveredServers = make(map[string]discoveredServer)
+	}
+	for agentUrl, server := range found {
+		j.discoveredServers[agentUrl] = discoveredServer{Server: server, LastSeen: now}
+	}
+	expireDiscoveredServers(j.discoveredServers, now, ttl)
+}
+
+// expireDiscoveredServers drops every entry whose LastSeen is older than ttl.
+func expireDiscoveredServers(servers map[string]discoveredServer, now time.Time, ttl time.Duration) {
+	for agentUrl, ds := range servers {
+		if now.Sub(ds.LastSeen) > ttl {
+			delete(servers, agentUrl)
+		}
+	}
+}
+
+// discoveryLoop runs discoverServers on its own ticker until discoveryStop is
+// closed. It is started from Start so a multi-second discovery round-trip
+// never stalls a Gather call.
+func (j *Jolokia) discoveryLoop(interval time.Duration) {
+	defer j.discoveryWg.Done()
+
+	j.discoverServers()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			j.discoverServers()
+		case <-j.discoveryStop:
+			return
+		}
+	}
+}
+
+// Start implements telegraf.ServiceInput. When discovery is enabled it
+// kicks off discoveryLoop in the background so Gather never blocks on a
+// multicast round-trip.
+func (j *Jolokia) Start(_ telegraf.Accumulator) error {
+	if !j.Discovery.Enabled {
+		return nil
+	}
+
+	interval := j.Discovery.Interval.Duration
+	if interval <= 0 {
+		interval = DefaultDiscoveryInterval.Duration
+	}
+
+	j.discoveryStop = make(chan struct{})
+	j.discoveryWg.Add(1)
+	go j.discoveryLoop(interval)
 
 	return nil
 }
 
+// Stop implements telegraf.ServiceInput.
+func (j *Jolokia) Stop() {
+	if j.discoveryStop == nil {
+		return
+	}
+	close(j.discoveryStop)
+	j.discoveryWg.Wait()
+}
+
+// effectiveServers returns the statically configured servers plus, when
+// discovery is enabled, every agent discoveryLoop has found and not yet
+// expired. It never triggers discovery itself.
+func (j *Jolokia) effectiveServers() []Server {
+	servers := append([]Server(nil), j.Servers...)
+
+	if !j.Discovery.Enabled {
+		return servers
+	}
+
+	j.discoveryMu.Lock()
+	for _, ds := range j.discoveredServers {
+		servers = append(servers, ds.Server)
+	}
+	j.discoveryMu.Unlock()
+
+	return servers
+}
+
 func (j *Jolokia) Gather(acc telegraf.Accumulator) error {
 
 	if j.jClient == nil {
@@ -382,40 +940,85 @@ func (j *Jolokia) Gather(acc telegraf.Accumulator) error {
 		}}
 	}
 
-	servers := j.Servers
+	servers := j.effectiveServers()
 	metrics := j.Metrics
-	defaultTags := make(map[string]string)
 
-	for _, server := range servers {
-		defaultTags["jolokia_name"] = server.Name
-		defaultTags["jolokia_port"] = server.Port
-		defaultTags["jolokia_host"] = server.Host
-
-		for _, metric := range metrics {
-			req, err := j.prepareRequest(server, metric)
-			if err != nil {
-				return err
-			}
+	if len(metrics) == 0 {
+		return nil
+	}
 
-			out, err := j.doRequest(req)
+	maxConcurrentRequests := j.MaxConcurrentRequests
+	if maxConcurrentRequests <= 0 {
+		maxConcurrentRequests = DefaultMaxConcurrentRequests
+	}
 
-			if err != nil {
-				fmt.Printf("Error handling response: %s\n", err)
-			} else {
-				j.extractMetric(out, metric, defaultTags, acc)
-			}
-		}
+	var wg sync.WaitGroup
+	limiter := make(chan struct{}, maxConcurrentRequests)
+
+	for _, server := range servers {
+		wg.Add(1)
+		limiter <- struct{}{}
+		go func(server Server) {
+			defer wg.Done()
+			defer func() { <-limiter }()
+			j.gatherServer(server, metrics, acc)
+		}(server)
 	}
 
+	wg.Wait()
+
 	return nil
 }
 
+// gatherServer resolves and gathers every metric for a single server. It is
+// safe to call concurrently for different servers.
+func (j *Jolokia) gatherServer(server Server, metrics []Metric, acc telegraf.Accumulator) {
+	defaultTags := map[string]string{
+		"jolokia_name": server.Name,
+		"jolokia_port": server.Port,
+		"jolokia_host": server.Host,
+	}
+	for k, v := range server.Tags {
+		defaultTags[k] = v
+	}
+
+	serverMetrics, err := j.expandSearchMetrics(server, metrics, acc)
+	if err != nil {
+		acc.AddError(fmt.Errorf("error resolving search metrics on server %s: %s", server.Name, err))
+		return
+	}
+
+	if len(serverMetrics) == 0 {
+		return
+	}
+
+	req, err := j.prepareRequest(server, serverMetrics)
+	if err != nil {
+		acc.AddError(fmt.Errorf("error preparing request for server %s: %s", server.Name, err))
+		return
+	}
+
+	results, err := j.doBulkRequest(server.Name, req, serverMetrics, acc)
+	if err != nil {
+		acc.AddError(fmt.Errorf("error handling response from server %s: %s", server.Name, err))
+		return
+	}
+
+	j.extractMetrics(results, serverMetrics, defaultTags, acc)
+}
+
 func init() {
 	inputs.Add("jolokia", func() telegraf.Input {
 		return &Jolokia{
 			ResponseHeaderTimeout: DefaultResponseHeaderTimeout,
 			ClientTimeout:         DefaultClientTimeout,
 			Delimiter:             "_",
+			MaxConcurrentRequests: DefaultMaxConcurrentRequests,
+			Discovery: Discovery{
+				Interval: DefaultDiscoveryInterval,
+				Timeout:  DefaultDiscoveryTimeout,
+				TTL:      DefaultDiscoveryTTL,
+			},
 		}
 	})
 }
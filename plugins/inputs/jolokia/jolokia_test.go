@@ -0,0 +1,251 @@
+package jolokia
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/influxdata/telegraf/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeClient is a JolokiaClient test double that decodes the bulk request
+// body and hands it to handle to build the (equally bulk) response.
+type fakeClient struct {
+	handle func(ops []map[string]interface{}) []map[string]interface{}
+}
+
+func (c *fakeClient) MakeRequest(req *http.Request) (*http.Response, error) {
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var ops []map[string]interface{}
+	if err := json.Unmarshal(body, &ops); err != nil {
+		return nil, err
+	}
+
+	respBody, err := json.Marshal(c.handle(ops))
+	if err != nil {
+		return nil, err
+	}
+
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       ioutil.NopCloser(bytes.NewReader(respBody)),
+	}, nil
+}
+
+func TestCoerceFieldType(t *testing.T) {
+	tests := []struct {
+		fieldType string
+		input     interface{}
+		expected  interface{}
+	}{
+		{"int", float64(42), int64(42)},
+		{"int", "42", int64(42)},
+		{"int", "not-a-number", "not-a-number"},
+		{"float", "3.5", float64(3.5)},
+		{"float", float64(3.5), float64(3.5)},
+		{"bool", "true", true},
+		{"bool", float64(0), false},
+		{"bool", float64(1), true},
+		{"string", float64(42), "42"},
+		{"", float64(42), float64(42)},
+	}
+
+	for _, tt := range tests {
+		actual := coerceFieldType(tt.fieldType, tt.input)
+		assert.Equal(t, tt.expected, actual, "fieldType=%q input=%v", tt.fieldType, tt.input)
+	}
+}
+
+func TestFieldAllowed(t *testing.T) {
+	tests := []struct {
+		name    string
+		metric  Metric
+		key     string
+		allowed bool
+	}{
+		{"no filters", Metric{}, "heap_used", true},
+		{"include match", Metric{Include: []string{"*used"}}, "heap_used", true},
+		{"include no match", Metric{Include: []string{"*used"}}, "heap_committed", false},
+		{"exclude wins", Metric{Include: []string{"heap_*"}, Exclude: []string{"*committed"}}, "heap_committed", false},
+		{"exclude only", Metric{Exclude: []string{"*committed"}}, "heap_used", true},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.allowed, fieldAllowed(tt.metric, tt.key), tt.name)
+	}
+}
+
+var searchMatches = []string{
+	"Catalina:type=ThreadPool,name=http-1",
+	"Catalina:type=ThreadPool,name=http-2",
+	"Catalina:type=ThreadPool,name=http-3",
+}
+
+func searchAndReadClient() *fakeClient {
+	return &fakeClient{handle: func(ops []map[string]interface{}) []map[string]interface{} {
+		results := make([]map[string]interface{}, len(ops))
+		for i, op := range ops {
+			if op["type"] == "search" {
+				names := make([]interface{}, len(searchMatches))
+				for j, m := range searchMatches {
+					names[j] = m
+				}
+				results[i] = map[string]interface{}{"status": 200.0, "value": names}
+				continue
+			}
+			results[i] = map[string]interface{}{"status": 200.0, "value": 5.0}
+		}
+		return results
+	}}
+}
+
+func TestExpandSearchMetricsProducesOneReadPerMatch(t *testing.T) {
+	j := &Jolokia{jClient: searchAndReadClient(), Delimiter: "_"}
+	search := Metric{Name: "pool_size", Mbean: "Catalina:type=ThreadPool,name=*", Attribute: "currentThreadCount", Type: "search"}
+
+	var acc testutil.Accumulator
+	expanded, err := j.expandSearchMetrics(Server{Name: "srv1"}, []Metric{search}, &acc)
+	require.NoError(t, err)
+	require.Len(t, expanded, len(searchMatches))
+
+	seen := make(map[string]bool)
+	for _, m := range expanded {
+		assert.Equal(t, "read", m.Type)
+		assert.Equal(t, "pool_size", m.Name)
+		seen[m.Mbean] = true
+	}
+	assert.Len(t, seen, len(searchMatches))
+}
+
+// TestGatherSearchDoesNotDuplicatePoints is a regression test: with N
+// matches for a single search metric, Gather must emit exactly N points,
+// not N^2 (see review of chunk0-2's initial Name-keyed bulk dispatch).
+func TestGatherSearchDoesNotDuplicatePoints(t *testing.T) {
+	search := Metric{Name: "pool_size", Mbean: "Catalina:type=ThreadPool,name=*", Attribute: "currentThreadCount", Type: "search"}
+
+	j := &Jolokia{
+		jClient:               searchAndReadClient(),
+		Delimiter:             "_",
+		MaxConcurrentRequests: DefaultMaxConcurrentRequests,
+		Servers:               []Server{{Name: "srv1", Host: "localhost", Port: "8080"}},
+		Metrics:               []Metric{search},
+	}
+
+	var acc testutil.Accumulator
+	require.NoError(t, j.Gather(&acc))
+
+	assert.Len(t, acc.Metrics, len(searchMatches))
+}
+
+// execClient answers a bulk request of exec operations: "gc" (void, returns
+// a null value) and "heapUsage" (returns a scalar).
+func execClient() *fakeClient {
+	return &fakeClient{handle: func(ops []map[string]interface{}) []map[string]interface{} {
+		results := make([]map[string]interface{}, len(ops))
+		for i, op := range ops {
+			if op["operation"] == "gc" {
+				results[i] = map[string]interface{}{"status": 200.0, "value": nil}
+				continue
+			}
+			results[i] = map[string]interface{}{"status": 200.0, "value": 123.0}
+		}
+		return results
+	}}
+}
+
+func TestGatherExecHandlesVoidAndValueReturns(t *testing.T) {
+	gc := Metric{Name: "gc", Mbean: "java.lang:type=Memory", Operation: "gc", Type: "exec"}
+	heap := Metric{Name: "heap_usage", Mbean: "java.lang:type=Memory", Operation: "heapUsage", Type: "exec"}
+
+	j := &Jolokia{
+		jClient:               execClient(),
+		Delimiter:             "_",
+		MaxConcurrentRequests: DefaultMaxConcurrentRequests,
+		Servers:               []Server{{Name: "srv1", Host: "localhost", Port: "8080"}},
+		Metrics:               []Metric{gc, heap},
+	}
+
+	var acc testutil.Accumulator
+	require.NoError(t, j.Gather(&acc))
+
+	// The void "gc" operation must not emit a field at all, and must not
+	// produce an error -- only "heap_usage" should be reported.
+	require.Len(t, acc.Metrics, 1)
+	acc.AssertContainsFields(t, "jolokia", map[string]interface{}{"heap_usage": float64(123)})
+}
+
+func TestExpireDiscoveredServers(t *testing.T) {
+	now := time.Now()
+	servers := map[string]discoveredServer{
+		"http://fresh:8080/jolokia/": {Server: Server{Name: "fresh"}, LastSeen: now},
+		"http://stale:8080/jolokia/": {Server: Server{Name: "stale"}, LastSeen: now.Add(-10 * time.Minute)},
+	}
+
+	expireDiscoveredServers(servers, now, 5*time.Minute)
+
+	assert.Contains(t, servers, "http://fresh:8080/jolokia/")
+	assert.NotContains(t, servers, "http://stale:8080/jolokia/")
+}
+
+// trackingClient records the high-water mark of concurrent MakeRequest
+// calls, holding each one open briefly so overlapping calls are observable.
+type trackingClient struct {
+	mu          sync.Mutex
+	inFlight    int
+	maxInFlight int
+}
+
+func (c *trackingClient) MakeRequest(req *http.Request) (*http.Response, error) {
+	c.mu.Lock()
+	c.inFlight++
+	if c.inFlight > c.maxInFlight {
+		c.maxInFlight = c.inFlight
+	}
+	c.mu.Unlock()
+
+	time.Sleep(20 * time.Millisecond)
+
+	c.mu.Lock()
+	c.inFlight--
+	c.mu.Unlock()
+
+	respBody, _ := json.Marshal([]map[string]interface{}{{"status": 200.0, "value": 1.0}})
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       ioutil.NopCloser(bytes.NewReader(respBody)),
+	}, nil
+}
+
+func TestGatherRespectsMaxConcurrentRequests(t *testing.T) {
+	client := &trackingClient{}
+
+	servers := make([]Server, 6)
+	for i := range servers {
+		servers[i] = Server{Name: "srv", Host: "localhost", Port: "8080"}
+	}
+
+	j := &Jolokia{
+		jClient:               client,
+		Delimiter:             "_",
+		MaxConcurrentRequests: 2,
+		Servers:               servers,
+		Metrics:               []Metric{{Name: "m", Mbean: "java.lang:type=Memory", Attribute: "HeapMemoryUsage"}},
+	}
+
+	var acc testutil.Accumulator
+	require.NoError(t, j.Gather(&acc))
+
+	// Require actual parallelism (not just the upper bound) so a regression
+	// to sequential gathering fails this test instead of passing trivially.
+	assert.Equal(t, 2, client.maxInFlight)
+}